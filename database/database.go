@@ -0,0 +1,73 @@
+// Package database provides a database/sql-style registry of output
+// backends. A backend registers itself from an init() function in its
+// own package (see database/postgis, database/ogr and database/gpkg),
+// and main selects one by name through Config.Type/the -output flag.
+package database
+
+import (
+	"fmt"
+	"goposm/mapping"
+)
+
+// DB is implemented by every output backend. Init creates the schema for
+// the tables declared in a mapping.Mapping; it's only safe to call at the
+// start of a full import (the postgis backend drops and recreates any
+// table that already exists, the gpkg backend fails on one). Attach instead populates a backend's in-memory knowledge
+// of those same tables without touching the schema, for -update against
+// a database an earlier Init already created. Insert/Update/Delete are
+// used both for the batched InsertBuffer pipeline of a full import and
+// for the row-at-a-time writes issued by -update; Insert takes the
+// element id alongside row, mirroring Update/Delete, so backends that
+// key rows by it (osm_id, a shapefile FID, ...) can store it without
+// Insert's callers having to fold it into row themselves.
+type DB interface {
+	Init(m *mapping.Mapping) error
+	Attach(m *mapping.Mapping) error
+	Insert(table string, id int64, row []interface{}) error
+	Update(table string, id int64, row []interface{}) error
+	Delete(table string, id int64) error
+	Close() error
+}
+
+// Driver is implemented by a backend package and registered with
+// Register so that Open can construct a DB from a Config.
+type Driver interface {
+	Open(conf Config) (DB, error)
+}
+
+// Config carries everything a Driver needs to open a DB. ConnectionParams
+// is driver specific: a libpq connection string for postgis, a directory
+// for shapefiles, a .gpkg file path for GeoPackage.
+type Config struct {
+	Type             string
+	ConnectionParams string
+	Srid             int
+	ImportBatchSize  int64
+}
+
+var drivers = make(map[string]Driver)
+
+// Register makes a driver available under name to Open and -output. It
+// is meant to be called from a backend package's init() function, not
+// directly by main.
+func Register(name string, driver Driver) {
+	if driver == nil {
+		panic("database: Register driver is nil")
+	}
+	if _, exists := drivers[name]; exists {
+		panic("database: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Open constructs a DB for conf.Type, which must have been registered by
+// a backend package imported for side effects, e.g.
+//
+//	import _ "goposm/database/postgis"
+func Open(conf Config) (DB, error) {
+	driver, ok := drivers[conf.Type]
+	if !ok {
+		return nil, fmt.Errorf("database: unknown driver %q (forgotten import?)", conf.Type)
+	}
+	return driver.Open(conf)
+}
@@ -0,0 +1,213 @@
+// Package gpkg is a GeoPackage (SQLite) output backend, registered as
+// "gpkg". ConnectionParams is the destination .gpkg file path.
+package gpkg
+
+import (
+	"database/sql"
+	"fmt"
+	"goposm/database"
+	"goposm/mapping"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	database.Register("gpkg", gpkgDriver{})
+}
+
+type gpkgDriver struct{}
+
+func (gpkgDriver) Open(conf database.Config) (database.DB, error) {
+	db, err := sql.Open("sqlite3", conf.ConnectionParams)
+	if err != nil {
+		return nil, err
+	}
+	batchSize := conf.ImportBatchSize
+	if batchSize <= 0 {
+		batchSize = 4096
+	}
+	return &GeoPackage{db: db, srid: conf.Srid, importBatchSize: batchSize}, nil
+}
+
+// GeoPackage buffers rows per table the same way PostGIS does and
+// flushes them as multi-row transactions, since SQLite also benefits
+// from batching writes inside a single transaction.
+type GeoPackage struct {
+	db              *sql.DB
+	srid            int
+	importBatchSize int64
+	tables          map[string]*mapping.Table
+	pending         map[string][][]interface{}
+}
+
+func (g *GeoPackage) Init(m *mapping.Mapping) error {
+	g.tables = make(map[string]*mapping.Table)
+	g.pending = make(map[string][][]interface{})
+
+	if _, err := g.db.Exec(gpkgMetadataSchema); err != nil {
+		return fmt.Errorf("gpkg: initializing gpkg_* metadata tables: %v", err)
+	}
+
+	for _, table := range m.Tables {
+		g.tables[table.Name] = table
+		if err := g.createTable(table); err != nil {
+			return fmt.Errorf("gpkg: creating table %s: %v", table.Name, err)
+		}
+	}
+
+	for _, gen := range m.GeneralizedTables {
+		genTable, err := generalizedTable(g.tables, gen)
+		if err != nil {
+			return err
+		}
+		g.tables[gen.Name] = genTable
+		if err := g.createTable(genTable); err != nil {
+			return fmt.Errorf("gpkg: creating generalized table %s: %v", gen.Name, err)
+		}
+	}
+	return nil
+}
+
+// Attach populates g.tables/g.pending from m without touching the
+// schema, for -update against a .gpkg file an earlier Init already
+// created: re-running Init's CREATE TABLE would fail outright (the
+// table already exists) or, worse, against a fresh file silently start
+// -update from empty.
+func (g *GeoPackage) Attach(m *mapping.Mapping) error {
+	g.tables = make(map[string]*mapping.Table)
+	g.pending = make(map[string][][]interface{})
+
+	for _, table := range m.Tables {
+		g.tables[table.Name] = table
+	}
+	for _, gen := range m.GeneralizedTables {
+		genTable, err := generalizedTable(g.tables, gen)
+		if err != nil {
+			return err
+		}
+		g.tables[gen.Name] = genTable
+	}
+	return nil
+}
+
+func generalizedTable(tables map[string]*mapping.Table, gen *mapping.GeneralizedTable) (*mapping.Table, error) {
+	source, ok := tables[gen.SourceTable]
+	if !ok {
+		return nil, fmt.Errorf("gpkg: generalized table %s: unknown source table %s", gen.Name, gen.SourceTable)
+	}
+	return &mapping.Table{Name: gen.Name, Columns: source.Columns, GeometryType: source.GeometryType}, nil
+}
+
+func (g *GeoPackage) createTable(t *mapping.Table) error {
+	columns := ""
+	for _, col := range t.Columns {
+		columns += fmt.Sprintf(`, "%s" %s`, col.Name, col.Type)
+	}
+	if _, err := g.db.Exec(fmt.Sprintf(
+		`CREATE TABLE "%s" (fid INTEGER PRIMARY KEY, geom BLOB%s)`, t.Name, columns)); err != nil {
+		return err
+	}
+	_, err := g.db.Exec(
+		`INSERT INTO gpkg_geometry_columns (table_name, column_name, geometry_type_name, srs_id)
+		 VALUES (?, 'geom', ?, ?)`, t.Name, gpkgGeometryType(t.GeometryType), g.srid)
+	return err
+}
+
+// Insert queues row for table, explicitly setting fid to id so it
+// resolves to the same row Update/Delete later address by id, rather
+// than letting SQLite assign an unrelated rowid.
+func (g *GeoPackage) Insert(table string, id int64, row []interface{}) error {
+	g.pending[table] = append(g.pending[table], append([]interface{}{id}, row...))
+	if int64(len(g.pending[table])) >= g.importBatchSize {
+		return g.flush(table)
+	}
+	return nil
+}
+
+func (g *GeoPackage) Update(table string, id int64, row []interface{}) error {
+	t, ok := g.tables[table]
+	if !ok {
+		return fmt.Errorf("gpkg: unknown table %s", table)
+	}
+	sets := ""
+	for i, col := range t.Columns {
+		if i > 0 {
+			sets += ", "
+		}
+		sets += fmt.Sprintf(`"%s" = ?`, col.Name)
+	}
+	args := append(append([]interface{}{}, row[:len(row)-1]...), row[len(row)-1], id)
+	_, err := g.db.Exec(fmt.Sprintf(
+		`UPDATE "%s" SET %s, geom = ? WHERE fid = ?`, table, sets), args...)
+	return err
+}
+
+func (g *GeoPackage) Delete(table string, id int64) error {
+	_, err := g.db.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE fid = ?`, table), id)
+	return err
+}
+
+func (g *GeoPackage) Close() error {
+	for table := range g.pending {
+		if err := g.flush(table); err != nil {
+			return err
+		}
+	}
+	return g.db.Close()
+}
+
+func (g *GeoPackage) flush(table string) error {
+	rows := g.pending[table]
+	if len(rows) == 0 {
+		return nil
+	}
+	t := g.tables[table]
+	defer func() { g.pending[table] = g.pending[table][:0] }()
+
+	tx, err := g.db.Begin()
+	if err != nil {
+		return err
+	}
+	columns := "fid"
+	placeholders := "?"
+	for _, col := range t.Columns {
+		columns += fmt.Sprintf(`, "%s"`, col.Name)
+		placeholders += ", ?"
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf(
+		`INSERT INTO "%s" (%s, geom) VALUES (%s, ?)`, table, columns, placeholders))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		if _, err := stmt.Exec(row...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func gpkgGeometryType(t string) string {
+	switch t {
+	case "polygon":
+		return "MULTIPOLYGON"
+	case "linestring":
+		return "LINESTRING"
+	default:
+		return "POINT"
+	}
+}
+
+const gpkgMetadataSchema = `
+CREATE TABLE IF NOT EXISTS gpkg_geometry_columns (
+	table_name TEXT NOT NULL,
+	column_name TEXT NOT NULL,
+	geometry_type_name TEXT NOT NULL,
+	srs_id INTEGER NOT NULL,
+	PRIMARY KEY (table_name, column_name)
+);
+`
@@ -0,0 +1,48 @@
+package ogr
+
+/*
+#include <ogr_api.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// setFeatureFields sets every row value except the trailing geometry
+// column, following the same "fields then geometry" row layout that
+// writer.InsertBuffer rows use for every backend.
+func setFeatureFields(feature C.OGRFeatureH, row []interface{}) error {
+	for i := 0; i < len(row)-1; i++ {
+		s := fmt.Sprintf("%v", row[i])
+		cs := C.CString(s)
+		C.OGR_F_SetFieldString(feature, C.int(i), cs)
+		C.free(unsafe.Pointer(cs))
+	}
+	return nil
+}
+
+func setFeatureGeometry(feature C.OGRFeatureH, row []interface{}) error {
+	if len(row) == 0 {
+		return fmt.Errorf("ogr: row has no geometry column")
+	}
+	wkb, ok := row[len(row)-1].([]byte)
+	if !ok {
+		return fmt.Errorf("ogr: last row column is not WKB bytes")
+	}
+
+	var geom C.OGRGeometryH
+	cWkb := C.CBytes(wkb)
+	defer C.free(cWkb)
+	if C.OGR_G_CreateFromWkb((*C.uchar)(cWkb), nil, &geom, C.int(len(wkb))) != C.OGRERR_NONE {
+		return fmt.Errorf("ogr: parsing WKB geometry")
+	}
+	defer C.OGR_G_DestroyGeometry(geom)
+
+	if C.OGR_F_SetGeometry(feature, geom) != C.OGRERR_NONE {
+		return fmt.Errorf("ogr: attaching geometry to feature")
+	}
+	return nil
+}
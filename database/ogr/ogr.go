@@ -0,0 +1,207 @@
+// Package ogr is an OGR/GDAL-backed output backend, registered as
+// "shapefile". ConnectionParams is the destination directory; one .shp
+// is created per mapping table, matching the GDAL Shapefile driver's
+// one-layer-per-file convention.
+package ogr
+
+/*
+#cgo LDFLAGS: -lgdal
+#include <ogr_api.h>
+#include <ogr_srs_api.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"goposm/database"
+	"goposm/mapping"
+	"path/filepath"
+	"unsafe"
+)
+
+func init() {
+	database.Register("shapefile", shpDriver{})
+	C.OGRRegisterAll()
+}
+
+type shpDriver struct{}
+
+func (shpDriver) Open(conf database.Config) (database.DB, error) {
+	cDriver := C.CString("ESRI Shapefile")
+	defer C.free(unsafe.Pointer(cDriver))
+	drv := C.OGRGetDriverByName(cDriver)
+	if drv == nil {
+		return nil, fmt.Errorf("ogr: ESRI Shapefile driver not available")
+	}
+	batchSize := conf.ImportBatchSize
+	if batchSize <= 0 {
+		batchSize = 4096
+	}
+	return &Shapefile{
+		driver:          drv,
+		dir:             conf.ConnectionParams,
+		srid:            conf.Srid,
+		importBatchSize: batchSize,
+		layers:          make(map[string]C.OGRLayerH),
+		ds:              make(map[string]C.OGRDataSourceH),
+		pending:         make(map[string]int64),
+	}, nil
+}
+
+// Shapefile writes each mapping table as its own .shp/.dbf/.shx dataset
+// in dir, using OGR's C API directly (the same approach goposm/geom/geos
+// uses for GEOS) rather than a higher level Go wrapper.
+type Shapefile struct {
+	driver          C.OGRSFDriverH
+	dir             string
+	srid            int
+	importBatchSize int64
+	layers          map[string]C.OGRLayerH
+	ds              map[string]C.OGRDataSourceH
+	// pending counts features written to a layer's currently open OGR
+	// transaction, started lazily on the first Insert after Init or
+	// after the previous transaction was committed at importBatchSize.
+	pending map[string]int64
+}
+
+func (s *Shapefile) Init(m *mapping.Mapping) error {
+	srs := C.OSRNewSpatialReference(nil)
+	if srs == nil {
+		return fmt.Errorf("ogr: creating spatial reference for EPSG:%d", s.srid)
+	}
+	defer C.OSRDestroySpatialReference(srs)
+	if C.OSRImportFromEPSG(srs, C.int(s.srid)) != C.OGRERR_NONE {
+		return fmt.Errorf("ogr: importing EPSG:%d spatial reference", s.srid)
+	}
+
+	tables := append([]*mapping.Table{}, m.Tables...)
+	for _, gen := range m.GeneralizedTables {
+		var source *mapping.Table
+		for _, t := range m.Tables {
+			if t.Name == gen.SourceTable {
+				source = t
+			}
+		}
+		if source == nil {
+			return fmt.Errorf("ogr: generalized table %s: unknown source table %s", gen.Name, gen.SourceTable)
+		}
+		tables = append(tables, &mapping.Table{Name: gen.Name, Columns: source.Columns, GeometryType: source.GeometryType})
+	}
+
+	for _, table := range tables {
+		path := filepath.Join(s.dir, table.Name+".shp")
+		cPath := C.CString(path)
+		defer C.free(unsafe.Pointer(cPath))
+
+		ds := C.OGR_Dr_CreateDataSource(s.driver, cPath, nil)
+		if ds == nil {
+			return fmt.Errorf("ogr: creating data source %s", path)
+		}
+
+		cLayer := C.CString(table.Name)
+		defer C.free(unsafe.Pointer(cLayer))
+		layer := C.OGR_DS_CreateLayer(ds, cLayer, srs, geometryType(table.GeometryType), nil)
+		if layer == nil {
+			return fmt.Errorf("ogr: creating layer %s", table.Name)
+		}
+
+		for _, col := range table.Columns {
+			cName := C.CString(col.Name)
+			fieldDefn := C.OGR_Fld_Create(cName, C.OFTString)
+			C.OGR_L_CreateField(layer, fieldDefn, 1)
+			C.OGR_Fld_Destroy(fieldDefn)
+			C.free(unsafe.Pointer(cName))
+		}
+
+		s.ds[table.Name] = ds
+		s.layers[table.Name] = layer
+	}
+	return nil
+}
+
+// Attach is not supported: the Shapefile driver has no notion of
+// reopening an existing .shp for further writes (OGR_Dr_CreateDataSource
+// always creates a fresh dataset), so Attach returns an error and -update
+// stops; a full import is the only way to refresh a shapefile output.
+func (s *Shapefile) Attach(m *mapping.Mapping) error {
+	return fmt.Errorf("ogr: shapefile backend does not support -update, re-run a full import")
+}
+
+func (s *Shapefile) Insert(table string, id int64, row []interface{}) error {
+	layer, ok := s.layers[table]
+	if !ok {
+		return fmt.Errorf("ogr: unknown table %s", table)
+	}
+	if s.pending[table] == 0 {
+		C.OGR_L_StartTransaction(layer)
+	}
+
+	feature := C.OGR_F_Create(C.OGR_L_GetLayerDefn(layer))
+	defer C.OGR_F_Destroy(feature)
+
+	C.OGR_F_SetFID(feature, C.GIntBig(id))
+	if err := setFeatureFields(feature, row); err != nil {
+		return err
+	}
+	if err := setFeatureGeometry(feature, row); err != nil {
+		return err
+	}
+	if C.OGR_L_CreateFeature(layer, feature) != C.OGRERR_NONE {
+		return fmt.Errorf("ogr: writing feature to %s", table)
+	}
+
+	s.pending[table]++
+	if s.pending[table] >= s.importBatchSize {
+		return s.flush(table)
+	}
+	return nil
+}
+
+// flush commits the batch of features written to table's layer since its
+// transaction was started, the shapefile-driver equivalent of the
+// one-transaction-per-batch inserts the PostGIS and GeoPackage backends do.
+func (s *Shapefile) flush(table string) error {
+	layer := s.layers[table]
+	if C.OGR_L_CommitTransaction(layer) != C.OGRERR_NONE {
+		return fmt.Errorf("ogr: committing batch for %s", table)
+	}
+	s.pending[table] = 0
+	return nil
+}
+
+// Update and Delete are not supported for shapefiles and always return an
+// error; a full import is the only way to refresh a shapefile output.
+func (s *Shapefile) Update(table string, id int64, row []interface{}) error {
+	return fmt.Errorf("ogr: shapefile backend does not support -update, re-run a full import")
+}
+
+func (s *Shapefile) Delete(table string, id int64) error {
+	return fmt.Errorf("ogr: shapefile backend does not support -update, re-run a full import")
+}
+
+func (s *Shapefile) Close() error {
+	for table, pending := range s.pending {
+		if pending > 0 {
+			if err := s.flush(table); err != nil {
+				return err
+			}
+		}
+	}
+	for name, ds := range s.ds {
+		C.OGR_DS_Destroy(ds)
+		delete(s.ds, name)
+	}
+	return nil
+}
+
+func geometryType(t string) C.OGRwkbGeometryType {
+	switch t {
+	case "polygon":
+		return C.wkbMultiPolygon
+	case "linestring":
+		return C.wkbLineString
+	default:
+		return C.wkbPoint
+	}
+}
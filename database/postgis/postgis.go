@@ -0,0 +1,135 @@
+// Package postgis is the PostGIS output backend. It was the only backend
+// before database.Open became registry-based; it now registers itself
+// under the name "postgis" like any other goposm/database driver.
+package postgis
+
+import (
+	"database/sql"
+	"fmt"
+	"goposm/database"
+	"goposm/mapping"
+
+	_ "github.com/lib/pq"
+)
+
+func init() {
+	database.Register("postgis", pgDriver{})
+}
+
+type pgDriver struct{}
+
+func (pgDriver) Open(conf database.Config) (database.DB, error) {
+	db, err := sql.Open("postgres", conf.ConnectionParams)
+	if err != nil {
+		return nil, err
+	}
+	batchSize := conf.ImportBatchSize
+	if batchSize <= 0 {
+		batchSize = 4096
+	}
+	return &PostGIS{db: db, srid: conf.Srid, importBatchSize: batchSize}, nil
+}
+
+// PostGIS writes rows into PostGIS geometry tables, batching inserts up
+// to importBatchSize rows into one transaction to amortize commits.
+type PostGIS struct {
+	db              *sql.DB
+	srid            int
+	importBatchSize int64
+	tables          map[string]*mapping.Table
+	pending         map[string][][]interface{}
+}
+
+func (p *PostGIS) Init(m *mapping.Mapping) error {
+	p.tables = make(map[string]*mapping.Table)
+	p.pending = make(map[string][][]interface{})
+
+	for _, table := range m.Tables {
+		p.tables[table.Name] = table
+		if _, err := p.db.Exec(createTableSQL(table, p.srid)); err != nil {
+			return fmt.Errorf("postgis: creating table %s: %v", table.Name, err)
+		}
+	}
+
+	for _, gen := range m.GeneralizedTables {
+		genTable, err := generalizedTable(p.tables, gen)
+		if err != nil {
+			return err
+		}
+		p.tables[gen.Name] = genTable
+		if _, err := p.db.Exec(createTableSQL(genTable, p.srid)); err != nil {
+			return fmt.Errorf("postgis: creating generalized table %s: %v", gen.Name, err)
+		}
+	}
+	return nil
+}
+
+// Attach populates p.tables/p.pending from m without touching the
+// schema, for -update against a database an earlier Init already
+// created: re-running Init's DROP TABLE/CREATE TABLE here would destroy
+// the very import -update is meant to preserve.
+func (p *PostGIS) Attach(m *mapping.Mapping) error {
+	p.tables = make(map[string]*mapping.Table)
+	p.pending = make(map[string][][]interface{})
+
+	for _, table := range m.Tables {
+		p.tables[table.Name] = table
+	}
+	for _, gen := range m.GeneralizedTables {
+		genTable, err := generalizedTable(p.tables, gen)
+		if err != nil {
+			return err
+		}
+		p.tables[gen.Name] = genTable
+	}
+	return nil
+}
+
+func generalizedTable(tables map[string]*mapping.Table, gen *mapping.GeneralizedTable) (*mapping.Table, error) {
+	source, ok := tables[gen.SourceTable]
+	if !ok {
+		return nil, fmt.Errorf("postgis: generalized table %s: unknown source table %s", gen.Name, gen.SourceTable)
+	}
+	return &mapping.Table{Name: gen.Name, Columns: source.Columns, GeometryType: source.GeometryType}, nil
+}
+
+func (p *PostGIS) Insert(table string, id int64, row []interface{}) error {
+	p.pending[table] = append(p.pending[table], append([]interface{}{id}, row...))
+	if int64(len(p.pending[table])) >= p.importBatchSize {
+		return p.flush(table)
+	}
+	return nil
+}
+
+func (p *PostGIS) Update(table string, id int64, row []interface{}) error {
+	t, ok := p.tables[table]
+	if !ok {
+		return fmt.Errorf("postgis: unknown table %s", table)
+	}
+	return execUpdate(p.db, t, id, row)
+}
+
+func (p *PostGIS) Delete(table string, id int64) error {
+	_, err := p.db.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE osm_id = $1`, table), id)
+	return err
+}
+
+func (p *PostGIS) Close() error {
+	for table := range p.pending {
+		if err := p.flush(table); err != nil {
+			return err
+		}
+	}
+	return p.db.Close()
+}
+
+func (p *PostGIS) flush(table string) error {
+	rows := p.pending[table]
+	if len(rows) == 0 {
+		return nil
+	}
+	t := p.tables[table]
+	err := bulkInsert(p.db, t, rows)
+	p.pending[table] = p.pending[table][:0]
+	return err
+}
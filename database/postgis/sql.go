@@ -0,0 +1,105 @@
+package postgis
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"goposm/mapping"
+)
+
+// createTableSQL builds a CREATE TABLE statement from a mapping table
+// definition, appending the geometry column last with the configured
+// SRID so later generalized-table DDL can reuse the same column list.
+func createTableSQL(t *mapping.Table, srid int) string {
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, `DROP TABLE IF EXISTS "%s";`, t.Name)
+	fmt.Fprintf(&buf, `CREATE TABLE "%s" (osm_id BIGINT PRIMARY KEY`, t.Name)
+	for _, col := range t.Columns {
+		fmt.Fprintf(&buf, `, "%s" %s`, col.Name, col.Type)
+	}
+	buf.WriteString(");")
+	fmt.Fprintf(&buf, `SELECT AddGeometryColumn('%s', 'geometry', %d, '%s', 2);`,
+		t.Name, srid, t.GeometryType)
+	return buf.String()
+}
+
+// bulkInsert writes rows, each an (osm_id, column values..., geometry)
+// tuple as produced by PostGIS.Insert, which prepends the element id
+// ahead of the row mapping.Match.Row built.
+func bulkInsert(db *sql.DB, t *mapping.Table, rows [][]interface{}) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	columns := insertColumnList(t)
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf(
+		`INSERT INTO "%s" (%s) VALUES (%s)`,
+		t.Name, quoteColumns(columns), joinComma(placeholders)))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		if _, err := stmt.Exec(row...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func execUpdate(db *sql.DB, t *mapping.Table, id int64, row []interface{}) error {
+	columns := columnList(t)
+	sets := make([]string, len(columns))
+	for i, col := range columns {
+		sets[i] = fmt.Sprintf(`"%s" = $%d`, col, i+1)
+	}
+	args := append(append([]interface{}{}, row...), id)
+	_, err := db.Exec(fmt.Sprintf(
+		`UPDATE "%s" SET %s WHERE osm_id = $%d`,
+		t.Name, joinComma(sets), len(columns)+1), args...)
+	return err
+}
+
+func columnList(t *mapping.Table) []string {
+	columns := make([]string, 0, len(t.Columns)+1)
+	for _, col := range t.Columns {
+		columns = append(columns, col.Name)
+	}
+	columns = append(columns, "geometry")
+	return columns
+}
+
+// insertColumnList is columnList with osm_id prepended, matching the
+// (id, row...) tuples PostGIS.Insert hands to bulkInsert. It's kept
+// separate from columnList, which execUpdate also uses to build an
+// UPDATE's SET clause and must not include the primary key there.
+func insertColumnList(t *mapping.Table) []string {
+	return append([]string{"osm_id"}, columnList(t)...)
+}
+
+func quoteColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = fmt.Sprintf(`"%s"`, c)
+	}
+	return joinComma(quoted)
+}
+
+func joinComma(parts []string) string {
+	buf := bytes.Buffer{}
+	for i, p := range parts {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(p)
+	}
+	return buf.String()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"goposm/geom/geos"
+	"goposm/mapping"
+	"goposm/writer"
+)
+
+// insertWithGeneralized inserts row into table, and then, for every
+// generalized table derived from table (see mapping.GeneralizedTable),
+// simplifies row's trailing geometry column to that table's configured
+// tolerance and inserts the result into the generalized table. row is
+// expected to end with the WKB geometry column, the same convention
+// every output backend's Insert uses.
+func insertWithGeneralized(insertBuffer *writer.InsertBuffer, tagmapping *mapping.Mapping, g *geos.Geos, table string, id int64, row []interface{}) error {
+	insertBuffer.Insert(table, id, row)
+
+	genTables := tagmapping.GeneralizedTablesFor(table)
+	if len(genTables) == 0 {
+		return nil
+	}
+
+	wkb, ok := row[len(row)-1].([]byte)
+	if !ok {
+		return nil
+	}
+	geom, err := g.FromWKB(wkb)
+	if err != nil {
+		return err
+	}
+	defer g.Destroy(geom)
+
+	for _, gen := range genTables {
+		var simplified *geos.Geom
+		if gen.SimplifyPreserveTopology {
+			simplified, err = g.SimplifyPreserveTopology(geom, gen.Tolerance)
+		} else {
+			simplified, err = g.Simplify(geom, gen.Tolerance)
+		}
+		if err != nil {
+			return err
+		}
+
+		simplifiedWKB, err := g.ToWKB(simplified)
+		g.Destroy(simplified)
+		if err != nil {
+			return err
+		}
+
+		genRow := append(append([]interface{}{}, row[:len(row)-1]...), simplifiedWKB)
+		insertBuffer.Insert(gen.Name, id, genRow)
+	}
+	return nil
+}
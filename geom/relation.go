@@ -0,0 +1,172 @@
+package geom
+
+import (
+	"fmt"
+	"goposm/element"
+	"goposm/geom/geos"
+)
+
+// relationError is a leveled error for BuildRelation failures that are
+// worth logging (as opposed to ringNotClosedError's Level() 0, which is
+// expected often enough at extract boundaries that main stays quiet
+// about it).
+type relationError struct {
+	msg string
+}
+
+func (e relationError) Error() string { return e.msg }
+func (e relationError) Level() int    { return 1 }
+
+// BuildRelation assembles the way members of an OSM multipolygon
+// relation into a GEOS MultiPolygon and stores its WKB in r.Geom. It
+// joins members sharing endpoints into closed rings, classifies each
+// ring as outer or inner (from its member role, or by testing whether
+// another ring's polygon contains it when the role is blank), nests inner rings into the outer ring
+// that contains them as holes, and unions the resulting shells into one
+// MultiPolygon. If the relation carries no tags of its own (common for
+// multipolygons where only the outer way, or ways, are tagged), it
+// inherits the tags of its (single) outer ring, taking them from any one
+// of that ring's member ways since OSM convention has them agree.
+func BuildRelation(r *element.Relation) error {
+	members := wayMembers(r)
+	if len(members) == 0 {
+		return relationError{fmt.Sprintf("relation %d has no usable way members", r.Id)}
+	}
+
+	rings, err := buildRings(members)
+	if err != nil {
+		return err
+	}
+
+	g := geos.NewGEOS()
+	defer g.Finish()
+
+	classifyRings(g, rings)
+
+	var outers, inners []*ring
+	for _, rg := range rings {
+		if rg.role == "inner" {
+			inners = append(inners, rg)
+		} else {
+			outers = append(outers, rg)
+		}
+	}
+	if len(outers) == 0 {
+		return relationError{fmt.Sprintf("relation %d has no outer ring", r.Id)}
+	}
+
+	shells := make([]*geos.Geom, 0, len(outers))
+	for _, outer := range outers {
+		outerGeom, err := g.PolygonFromRings(outer.nodes, nil)
+		if err != nil {
+			return relationError{fmt.Sprintf("relation %d: building outer ring %v: %v", r.Id, wayIds(outer.ways), err)}
+		}
+
+		var holes [][]element.Node
+		for _, inner := range inners {
+			innerGeom, err := g.PolygonFromRings(inner.nodes, nil)
+			if err != nil {
+				continue
+			}
+			contains := g.Contains(outerGeom, innerGeom)
+			g.Destroy(innerGeom)
+			if contains {
+				holes = append(holes, inner.nodes)
+			}
+		}
+		g.Destroy(outerGeom)
+
+		shell, err := g.PolygonFromRings(outer.nodes, holes)
+		if err != nil {
+			return relationError{fmt.Sprintf("relation %d: building polygon for outer ring %v: %v", r.Id, wayIds(outer.ways), err)}
+		}
+		shells = append(shells, shell)
+	}
+
+	multiPolygon, err := g.Union(shells)
+	for _, shell := range shells {
+		g.Destroy(shell)
+	}
+	if err != nil {
+		return relationError{fmt.Sprintf("relation %d: unioning outer rings: %v", r.Id, err)}
+	}
+	defer g.Destroy(multiPolygon)
+
+	wkb, err := g.ToWKB(multiPolygon)
+	if err != nil {
+		return relationError{fmt.Sprintf("relation %d: %v", r.Id, err)}
+	}
+	r.Geom = wkb
+
+	if len(r.Tags) == 0 && len(outers) == 1 {
+		// the ring may have been joined starting from an untagged
+		// segment (common for a multi-way coastline/boundary tagged on
+		// only one sub-way), so take the first tagged way in the ring
+		// rather than assuming it's the first one joined.
+		for _, w := range outers[0].ways {
+			if len(w.Tags) > 0 {
+				r.Tags = w.Tags
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// wayMembers returns the relation's way members paired with their role,
+// dropping members whose way wasn't filled in (missing from the cache)
+// and members that aren't ways at all (nodes/sub-relations, which
+// multipolygon relations don't use).
+func wayMembers(r *element.Relation) []relationMember {
+	var members []relationMember
+	for _, m := range r.Members {
+		if m.Way == nil || len(m.Way.Nodes) == 0 {
+			continue
+		}
+		members = append(members, relationMember{way: m.Way, role: m.Role})
+	}
+	return members
+}
+
+// classifyRings assigns a role to every ring whose member ways left the
+// role blank, by testing whether the ring lies inside another ring: a
+// ring contained in another is an inner ring (a hole), otherwise it's
+// an outer shell. This mirrors how JOSM/osm2pgsql treat untagged roles
+// on simple (single outer, single inner) multipolygons.
+func classifyRings(g *geos.Geos, rings []*ring) {
+	polys := make([]*geos.Geom, len(rings))
+	for i, rg := range rings {
+		poly, err := g.PolygonFromRings(rg.nodes, nil)
+		if err != nil {
+			continue
+		}
+		polys[i] = poly
+	}
+	defer func() {
+		for _, p := range polys {
+			if p != nil {
+				g.Destroy(p)
+			}
+		}
+	}()
+
+	for i, rg := range rings {
+		if rg.role == "inner" || rg.role == "outer" {
+			continue
+		}
+		rg.role = "outer"
+		if polys[i] == nil {
+			continue
+		}
+		for j, other := range rings {
+			if i == j || polys[j] == nil {
+				continue
+			}
+			if g.Contains(polys[j], polys[i]) {
+				rg.role = "inner"
+				break
+			}
+		}
+	}
+}
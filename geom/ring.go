@@ -0,0 +1,127 @@
+package geom
+
+import (
+	"fmt"
+	"goposm/element"
+)
+
+// ring is a closed sequence of nodes assembled from one or more way
+// members of a multipolygon relation.
+type ring struct {
+	ways  []*element.Way
+	nodes []element.Node
+	role  string
+}
+
+func (r *ring) isClosed() bool {
+	return len(r.nodes) >= 4 && r.nodes[0].Id == r.nodes[len(r.nodes)-1].Id
+}
+
+// buildRings greedily joins each role group's way members end-to-end
+// into closed rings, reversing a way's direction when that's the only
+// way its ends line up with the ring being built. Ways that already
+// form a closed ring on their own (most simple lakes, buildings, etc.)
+// are handled as a degenerate one-way join.
+func buildRings(members []relationMember) ([]*ring, error) {
+	byRole := make(map[string][]*element.Way)
+	for _, m := range members {
+		byRole[m.role] = append(byRole[m.role], m.way)
+	}
+
+	var rings []*ring
+	for role, ways := range byRole {
+		remaining := append([]*element.Way{}, ways...)
+		for len(remaining) > 0 {
+			w := remaining[0]
+			remaining = remaining[1:]
+
+			r := &ring{ways: []*element.Way{w}, nodes: append([]element.Node{}, w.Nodes...), role: role}
+			for !r.isClosed() {
+				joined := false
+				for i, candidate := range remaining {
+					if r.tryJoin(candidate) {
+						remaining = append(remaining[:i], remaining[i+1:]...)
+						joined = true
+						break
+					}
+				}
+				if !joined {
+					break
+				}
+			}
+			if !r.isClosed() {
+				return nil, ringNotClosedError{wayIds: wayIds(r.ways)}
+			}
+			rings = append(rings, r)
+		}
+	}
+	return rings, nil
+}
+
+func (r *ring) tryJoin(w *element.Way) bool {
+	if len(w.Nodes) == 0 {
+		return false
+	}
+	first := r.nodes[0].Id
+	last := r.nodes[len(r.nodes)-1].Id
+	wFirst := w.Nodes[0].Id
+	wLast := w.Nodes[len(w.Nodes)-1].Id
+
+	switch {
+	case last == wFirst:
+		r.nodes = append(r.nodes, w.Nodes[1:]...)
+	case last == wLast:
+		r.nodes = append(r.nodes, reverseNodes(w.Nodes)[1:]...)
+	case first == wLast:
+		r.nodes = append(append([]element.Node{}, w.Nodes[:len(w.Nodes)-1]...), r.nodes...)
+	case first == wFirst:
+		rev := reverseNodes(w.Nodes)
+		r.nodes = append(append([]element.Node{}, rev[:len(rev)-1]...), r.nodes...)
+	default:
+		return false
+	}
+	r.ways = append(r.ways, w)
+	return true
+}
+
+func reverseNodes(nodes []element.Node) []element.Node {
+	out := make([]element.Node, len(nodes))
+	for i, n := range nodes {
+		out[len(nodes)-1-i] = n
+	}
+	return out
+}
+
+func wayIds(ways []*element.Way) []int64 {
+	ids := make([]int64, len(ways))
+	for i, w := range ways {
+		ids[i] = w.Id
+	}
+	return ids
+}
+
+// relationMember pairs a filled-in way with the role it has in the
+// relation being built, after members without a usable way (missing
+// from the cache, or a node/relation member rather than a way) have
+// been filtered out.
+type relationMember struct {
+	way  *element.Way
+	role string
+}
+
+// ringNotClosedError is returned when a role group's way members don't
+// join into a closed ring, e.g. because a member way is missing from
+// the cache (common at the edge of a regional extract). It is reported
+// at Level() 0 so main skips the relation without logging noise for an
+// expected, non-fatal condition.
+type ringNotClosedError struct {
+	wayIds []int64
+}
+
+func (e ringNotClosedError) Error() string {
+	return fmt.Sprintf("geom: ways %v do not form a closed ring", e.wayIds)
+}
+
+func (e ringNotClosedError) Level() int {
+	return 0
+}
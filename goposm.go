@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"goposm/cache"
 	"goposm/database"
+	_ "goposm/database/gpkg"
+	_ "goposm/database/ogr"
 	_ "goposm/database/postgis"
 	"goposm/element"
 	"goposm/geom"
 	"goposm/geom/geos"
+	"goposm/limit"
 	"goposm/mapping"
 	"goposm/parser"
 	"goposm/proj"
@@ -50,7 +53,23 @@ type ErrorLevel interface {
 	Level() int
 }
 
-func parse(cache *cache.OSMCache, progress *stats.Statistics, tagmapping *mapping.Mapping, filename string) {
+// filterByBBox drops nodes outside limiter's bbox before they reach the
+// coord cache. This is a coarse pre-filter only: ways crossing the bbox
+// still need their full geometry clipped against the limit polygon in
+// the write phase, but skipping clearly-outside coordinates here keeps
+// the cache small for regional imports of large PBFs like planet
+// extracts.
+func filterByBBox(nodes []element.Node, limiter *limit.Limiter) []element.Node {
+	filtered := nodes[:0]
+	for _, n := range nodes {
+		if limiter.BBoxContains(n.Long, n.Lat) {
+			filtered = append(filtered, n)
+		}
+	}
+	return filtered
+}
+
+func parse(cache *cache.OSMCache, progress *stats.Statistics, tagmapping *mapping.Mapping, limiter *limit.Limiter, filename string) {
 	nodes := make(chan []element.Node, 16)
 	coords := make(chan []element.Node, 16)
 	ways := make(chan []element.Way, 16)
@@ -115,6 +134,9 @@ func parse(cache *cache.OSMCache, progress *stats.Statistics, tagmapping *mappin
 				if skipCoords {
 					continue
 				}
+				if limiter != nil {
+					nds = filterByBBox(nds, limiter)
+				}
 				cache.Coords.PutCoords(nds)
 				progress.AddCoords(len(nds))
 			}
@@ -158,6 +180,10 @@ var (
 	connection     = flag.String("connection", "", "connection parameters")
 	diff           = flag.Bool("diff", false, "enable diff support")
 	mappingFile    = flag.String("mapping", "", "mapping file")
+	update         = flag.String("update", "", "apply an .osc.gz OsmChange file to an existing import")
+	output         = flag.String("output", "postgis", "output backend (postgis, shapefile, gpkg)")
+	srid           = flag.Int("srid", 3857, "EPSG code tables are written in")
+	limitto        = flag.String("limitto", "", "clip the import to a bbox (minx,miny,maxx,maxy) or a GeoJSON polygon file")
 )
 
 func main() {
@@ -218,9 +244,24 @@ func main() {
 		log.Fatal(err)
 	}
 
+	transformer, err := proj.NewTransformer(*srid)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var limiter *limit.Limiter
+	if *limitto != "" {
+		limitGeos := geos.NewGEOS()
+		limiter, err = limit.Load(limitGeos, transformer, *limitto)
+		limitGeos.Finish()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if *read != "" {
 		osmCache.Coords.SetLinearImport(true)
-		parse(osmCache, progress, tagmapping, *read)
+		parse(osmCache, progress, tagmapping, limiter, *read)
 		osmCache.Coords.SetLinearImport(false)
 		progress.Reset()
 		osmCache.Coords.Flush()
@@ -240,9 +281,10 @@ func main() {
 		waitFill := sync.WaitGroup{}
 		wayChan := make(chan []element.Way)
 		conf := database.Config{
-			Type:             "postgis",
+			Type:             *output,
 			ConnectionParams: *connection,
-			Srid:             3857,
+			Srid:             *srid,
+			ImportBatchSize:  dbImportBatchSize,
 		}
 		pg, err := database.Open(conf)
 		if err != nil {
@@ -259,6 +301,8 @@ func main() {
 
 		rel := osmCache.Relations.Iter()
 		polygons := tagmapping.PolygonMatcher()
+		relGeos := geos.NewGEOS()
+		defer relGeos.Finish()
 
 		for r := range rel {
 			progress.AddRelations(1)
@@ -280,7 +324,7 @@ func main() {
 					fmt.Println(err)
 					continue
 				}
-				proj.NodesToMerc(m.Way.Nodes)
+				proj.TransformNodes(transformer, m.Way.Nodes)
 			}
 
 			err = geom.BuildRelation(r)
@@ -294,9 +338,9 @@ func main() {
 				continue
 			}
 			if matches := polygons.Match(&r.OSMElem); len(matches) > 0 {
-				for _, match := range matches {
-					row := match.Row(&r.OSMElem)
-					insertBuffer.Insert(match.Table, row)
+				if err := writeClippedMatches(insertBuffer, tagmapping, limiter, relGeos, matches, &r.OSMElem); err != nil {
+					log.Println(err)
+					continue
 				}
 				err := osmCache.InsertedWays.PutMembers(r.Members)
 				if err != nil {
@@ -330,7 +374,7 @@ func main() {
 					if err != nil {
 						continue
 					}
-					proj.NodesToMerc(w.Nodes)
+					proj.TransformNodes(transformer, w.Nodes)
 					if matches := lineStrings.Match(&w.OSMElem); len(matches) > 0 {
 						// make copy to avoid interference with polygon matches
 						way := element.Way(*w)
@@ -344,9 +388,9 @@ func main() {
 							log.Println(err)
 							continue
 						}
-						for _, match := range matches {
-							row := match.Row(&way.OSMElem)
-							insertBuffer.Insert(match.Table, row)
+						if err := writeClippedMatches(insertBuffer, tagmapping, limiter, geos, matches, &way.OSMElem); err != nil {
+							log.Println(err)
+							continue
 						}
 
 					}
@@ -363,9 +407,9 @@ func main() {
 								log.Println(err)
 								continue
 							}
-							for _, match := range matches {
-								row := match.Row(&way.OSMElem)
-								insertBuffer.Insert(match.Table, row)
+							if err := writeClippedMatches(insertBuffer, tagmapping, limiter, geos, matches, &way.OSMElem); err != nil {
+								log.Println(err)
+								continue
 							}
 						}
 					}
@@ -388,7 +432,7 @@ func main() {
 		for n := range nodes {
 			progress.AddNodes(1)
 			if matches := points.Match(&n.OSMElem); len(matches) > 0 {
-				proj.NodeToMerc(n)
+				proj.TransformNode(transformer, n)
 				n.Geom, err = geom.PointWKB(geos, *n)
 				if err != nil {
 					if err, ok := err.(ErrorLevel); ok {
@@ -399,9 +443,9 @@ func main() {
 					log.Println(err)
 					continue
 				}
-				for _, match := range matches {
-					row := match.Row(&n.OSMElem)
-					insertBuffer.Insert(match.Table, row)
+				if err := writeClippedMatches(insertBuffer, tagmapping, limiter, geos, matches, &n.OSMElem); err != nil {
+					log.Println(err)
+					continue
 				}
 
 			}
@@ -411,6 +455,41 @@ func main() {
 		dbWriter.Close()
 
 	}
+
+	if *update != "" {
+		progress.Reset()
+
+		diffCache := cache.NewDiffCache(*cachedir)
+		if err := diffCache.Open(); err != nil {
+			log.Fatal(err)
+		}
+
+		conf := database.Config{
+			Type:             *output,
+			ConnectionParams: *connection,
+			Srid:             *srid,
+			// one row per batch, so every Insert issued by -update is
+			// written at once, in order with its Updates and Deletes
+			ImportBatchSize: 1,
+		}
+		pg, err := database.Open(conf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := pg.Attach(tagmapping); err != nil {
+			log.Fatal(err)
+		}
+
+		insertBuffer := writer.NewInsertBuffer()
+		dbWriter := writer.NewDbWriter(pg, insertBuffer.Out)
+
+		if err := applyChange(*update, osmCache, diffCache, tagmapping, transformer, dbWriter); err != nil {
+			log.Fatal(err)
+		}
+
+		insertBuffer.Close()
+		dbWriter.Close()
+	}
 	progress.Stop()
 
 	//parser.PBFStats(os.Args[1])
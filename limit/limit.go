@@ -0,0 +1,314 @@
+// Package limit restricts an import to a region, either a plain bbox or
+// a clipping polygon loaded from GeoJSON. It is used two ways: a coarse,
+// cheap bbox test during the parse phase to avoid caching coordinates
+// that can never end up in the output, and an exact geos-based
+// intersection against the clip polygon during the write phase.
+package limit
+
+import (
+	"encoding/json"
+	"fmt"
+	"goposm/element"
+	"goposm/geom/geos"
+	"goposm/proj"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Limiter answers bbox and polygon-clip questions for a single configured
+// -limitto region. Both the bbox and the clip polygon are kept in the
+// configured output projection (the same one proj.TransformNodes writes
+// way/relation/point geometry into), not in the WGS84 the -limitto spec
+// is given in, so ClipWKB can compare them directly against elem.Geom.
+// The polygon is kept as WKB rather than a live *geos.Geom so a Limiter
+// can be shared across the per-goroutine geos.Geos contexts the write
+// phase uses.
+type Limiter struct {
+	// minX/minY/maxX/maxY and polygonWKB are in the configured output
+	// SRID, for comparison against already-projected elem.Geom in
+	// ClipWKB.
+	minX, minY, maxX, maxY float64
+	polygonWKB             []byte
+
+	// wgs84Min/MaxLong/Lat are the original, unprojected -limitto bbox,
+	// for the coarse pre-filter in BBoxContains, which runs on node
+	// coordinates before proj.TransformNodes has touched them.
+	wgs84MinLong, wgs84MinLat, wgs84MaxLong, wgs84MaxLat float64
+}
+
+// Load builds a Limiter from a -limitto flag value: either a
+// "minx,miny,maxx,maxy" WGS84 bbox, or the path to a GeoJSON file
+// containing a single (Multi)Polygon geometry or Feature, also in
+// WGS84 as GeoJSON requires. transformer reprojects the bbox corners
+// and polygon vertices into the configured output SRID, the projection
+// every other geometry is written in by the time it reaches ClipWKB.
+func Load(g *geos.Geos, transformer proj.Transformer, spec string) (*Limiter, error) {
+	if looksLikeBBox(spec) {
+		return loadBBox(transformer, spec)
+	}
+	return loadGeoJSON(g, transformer, spec)
+}
+
+func looksLikeBBox(spec string) bool {
+	return strings.Count(spec, ",") == 3 && !strings.HasSuffix(spec, ".geojson") && !strings.HasSuffix(spec, ".json")
+}
+
+func loadBBox(transformer proj.Transformer, spec string) (*Limiter, error) {
+	parts := strings.Split(spec, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("limit: invalid bbox %q, want minx,miny,maxx,maxy", spec)
+	}
+	coords := make([]float64, 4)
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("limit: invalid bbox %q: %v", spec, err)
+		}
+		coords[i] = v
+	}
+	minX, minY, maxX, maxY := transformBounds(transformer, coords[0], coords[1], coords[2], coords[3])
+	return &Limiter{
+		minX: minX, minY: minY, maxX: maxX, maxY: maxY,
+		wgs84MinLong: coords[0], wgs84MinLat: coords[1], wgs84MaxLong: coords[2], wgs84MaxLat: coords[3],
+	}, nil
+}
+
+// transformBounds projects all four corners of a WGS84 bbox rather than
+// just the opposing min/max corner, since a projection (e.g. a conic or
+// transverse one; Web Mercator does keep it) doesn't necessarily keep a
+// north-up axis-aligned box axis-aligned.
+func transformBounds(transformer proj.Transformer, minLong, minLat, maxLong, maxLat float64) (minX, minY, maxX, maxY float64) {
+	corners := [][2]float64{
+		{minLong, minLat}, {minLong, maxLat}, {maxLong, minLat}, {maxLong, maxLat},
+	}
+	for i, c := range corners {
+		x, y := transformer.Transform(c[0], c[1])
+		if i == 0 || x < minX {
+			minX = x
+		}
+		if i == 0 || x > maxX {
+			maxX = x
+		}
+		if i == 0 || y < minY {
+			minY = y
+		}
+		if i == 0 || y > maxY {
+			maxY = y
+		}
+	}
+	return minX, minY, maxX, maxY
+}
+
+type geojsonDoc struct {
+	Type        string          `json:"type"`
+	Geometry    json.RawMessage `json:"geometry"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+func loadGeoJSON(g *geos.Geos, transformer proj.Transformer, path string) (*Limiter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	doc := geojsonDoc{}
+	if err := json.NewDecoder(f).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("limit: parsing %s: %v", path, err)
+	}
+
+	raw := doc.Coordinates
+	typ := doc.Type
+	if doc.Type == "Feature" {
+		inner := geojsonDoc{}
+		if err := json.Unmarshal(doc.Geometry, &inner); err != nil {
+			return nil, fmt.Errorf("limit: parsing %s: %v", path, err)
+		}
+		raw = inner.Coordinates
+		typ = inner.Type
+	}
+
+	polygons, err := ringsFromGeoJSON(typ, raw)
+	if err != nil {
+		return nil, fmt.Errorf("limit: %s: %v", path, err)
+	}
+
+	wgs84MinLong, wgs84MinLat, wgs84MaxLong, wgs84MaxLat := nodeBounds(polygons)
+
+	shells := make([]*geos.Geom, 0, len(polygons))
+	for _, rings := range polygons {
+		for i := range rings {
+			transformRing(transformer, rings[i])
+		}
+		shell, err := g.PolygonFromRings(rings[0], rings[1:])
+		if err != nil {
+			for _, s := range shells {
+				g.Destroy(s)
+			}
+			return nil, fmt.Errorf("limit: %s: %v", path, err)
+		}
+		shells = append(shells, shell)
+	}
+
+	polygon, err := g.Union(shells)
+	for _, s := range shells {
+		g.Destroy(s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("limit: %s: %v", path, err)
+	}
+	defer g.Destroy(polygon)
+
+	polygonWKB, err := g.ToWKB(polygon)
+	if err != nil {
+		return nil, fmt.Errorf("limit: %s: %v", path, err)
+	}
+
+	minX, minY, maxX, maxY := polygon.Bounds()
+	return &Limiter{
+		minX: minX, minY: minY, maxX: maxX, maxY: maxY, polygonWKB: polygonWKB,
+		wgs84MinLong: wgs84MinLong, wgs84MinLat: wgs84MinLat, wgs84MaxLong: wgs84MaxLong, wgs84MaxLat: wgs84MaxLat,
+	}, nil
+}
+
+// nodeBounds returns the WGS84 bounding box of every ring point across
+// every polygon, before reprojection.
+func nodeBounds(polygons [][][]element.Node) (minLong, minLat, maxLong, maxLat float64) {
+	first := true
+	for _, rings := range polygons {
+		for _, ring := range rings {
+			for _, n := range ring {
+				if first {
+					minLong, maxLong, minLat, maxLat = n.Long, n.Long, n.Lat, n.Lat
+					first = false
+					continue
+				}
+				if n.Long < minLong {
+					minLong = n.Long
+				}
+				if n.Long > maxLong {
+					maxLong = n.Long
+				}
+				if n.Lat < minLat {
+					minLat = n.Lat
+				}
+				if n.Lat > maxLat {
+					maxLat = n.Lat
+				}
+			}
+		}
+	}
+	return minLong, minLat, maxLong, maxLat
+}
+
+// ringsFromGeoJSON parses the "coordinates" member of a GeoJSON Polygon
+// or MultiPolygon into [polygon][ring][point] nodes, still in the
+// WGS84 longitude/latitude the GeoJSON spec requires.
+func ringsFromGeoJSON(typ string, raw json.RawMessage) ([][][]element.Node, error) {
+	switch typ {
+	case "Polygon":
+		var rawRings [][][2]float64
+		if err := json.Unmarshal(raw, &rawRings); err != nil {
+			return nil, err
+		}
+		return [][][]element.Node{ringsFromCoords(rawRings)}, nil
+	case "MultiPolygon":
+		var rawPolygons [][][][2]float64
+		if err := json.Unmarshal(raw, &rawPolygons); err != nil {
+			return nil, err
+		}
+		polygons := make([][][]element.Node, len(rawPolygons))
+		for i, rawRings := range rawPolygons {
+			polygons[i] = ringsFromCoords(rawRings)
+		}
+		return polygons, nil
+	default:
+		return nil, fmt.Errorf("unsupported -limitto geometry type %q, want Polygon or MultiPolygon", typ)
+	}
+}
+
+func ringsFromCoords(rawRings [][][2]float64) [][]element.Node {
+	rings := make([][]element.Node, len(rawRings))
+	for i, rawRing := range rawRings {
+		ring := make([]element.Node, len(rawRing))
+		for j, pt := range rawRing {
+			ring[j] = element.Node{Long: pt[0], Lat: pt[1]}
+		}
+		rings[i] = ring
+	}
+	return rings
+}
+
+func transformRing(transformer proj.Transformer, nodes []element.Node) {
+	for i := range nodes {
+		nodes[i].Long, nodes[i].Lat = transformer.Transform(nodes[i].Long, nodes[i].Lat)
+	}
+}
+
+// BBoxContains is a coarse, cheap test used while parsing a PBF: nodes
+// entirely outside the bbox can never contribute to the output and don't
+// need to be cached. long/lat are still in WGS84 at this point in the
+// pipeline (coordinates are only reprojected in the write phase), so
+// BBoxContains compares against the untransformed bbox the Limiter was
+// given, not minX/minY/maxX/maxY.
+func (l *Limiter) BBoxContains(long, lat float64) bool {
+	return long >= l.wgs84MinLong && long <= l.wgs84MaxLong && lat >= l.wgs84MinLat && lat <= l.wgs84MaxLat
+}
+
+// ClipWKB intersects a way/relation/point geometry against the clip
+// polygon. If the Limiter was built from a plain bbox (no polygon
+// loaded), geometries are only bbox-tested and returned unmodified. A
+// multi-geometry result from clipping a way against a concave polygon is
+// returned as one WKB per part so each part becomes its own row.
+func (l *Limiter) ClipWKB(g *geos.Geos, wkb []byte) (clipped [][]byte, keep bool, err error) {
+	geom, err := g.FromWKB(wkb)
+	if err != nil {
+		return nil, false, err
+	}
+	defer g.Destroy(geom)
+
+	minX, minY, maxX, maxY := geom.Bounds()
+	if maxX < l.minX || minX > l.maxX || maxY < l.minY || minY > l.maxY {
+		return nil, false, nil
+	}
+
+	if l.polygonWKB == nil {
+		return [][]byte{wkb}, true, nil
+	}
+
+	polygon, err := g.FromWKB(l.polygonWKB)
+	if err != nil {
+		return nil, false, err
+	}
+	defer g.Destroy(polygon)
+
+	if !g.Intersects(geom, polygon) {
+		return nil, false, nil
+	}
+
+	intersection, err := g.Intersection(geom, polygon)
+	if err != nil {
+		return nil, false, err
+	}
+	defer g.Destroy(intersection)
+
+	if intersection.IsEmpty() {
+		return nil, false, nil
+	}
+
+	parts, err := g.Parts(intersection)
+	if err != nil {
+		return nil, false, err
+	}
+
+	clipped = make([][]byte, 0, len(parts))
+	for _, part := range parts {
+		partWKB, err := g.ToWKB(part)
+		if err != nil {
+			return nil, false, err
+		}
+		clipped = append(clipped, partWKB)
+	}
+	return clipped, true, nil
+}
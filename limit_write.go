@@ -0,0 +1,48 @@
+package main
+
+import (
+	"goposm/element"
+	"goposm/geom/geos"
+	"goposm/limit"
+	"goposm/mapping"
+	"goposm/writer"
+)
+
+// writeClippedMatches inserts a row per match for elem, then cascades
+// each insert into any generalized tables derived from that match's
+// table (see generalize.go). When limiter is set, elem.Geom is first
+// intersected against the clip polygon; a geometry that falls entirely
+// outside is dropped, and one split by a concave clip polygon into
+// several parts is inserted once per part so every part becomes its own
+// row.
+func writeClippedMatches(insertBuffer *writer.InsertBuffer, tagmapping *mapping.Mapping, limiter *limit.Limiter, g *geos.Geos, matches []mapping.Match, elem *element.OSMElem) error {
+	if limiter == nil {
+		for _, match := range matches {
+			if err := insertWithGeneralized(insertBuffer, tagmapping, g, match.Table, elem.Id, match.Row(elem)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	parts, keep, err := limiter.ClipWKB(g, elem.Geom)
+	if err != nil {
+		return err
+	}
+	if !keep {
+		return nil
+	}
+
+	origGeom := elem.Geom
+	defer func() { elem.Geom = origGeom }()
+
+	for _, part := range parts {
+		elem.Geom = part
+		for _, match := range matches {
+			if err := insertWithGeneralized(insertBuffer, tagmapping, g, match.Table, elem.Id, match.Row(elem)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
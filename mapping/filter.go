@@ -0,0 +1,40 @@
+package mapping
+
+import "goposm/element"
+
+// TagFilter drops the tags listed under tags.exclude in the mapping YAML
+// before elements are cached; every other tag is kept, whether or not a
+// table matches on it. With Tags.LoadAll set in the mapping YAML every tag
+// is kept regardless of Exclude.
+type TagFilter struct {
+	loadAll bool
+	exclude map[string]bool
+}
+
+// Filter removes excluded tags from *tags in place.
+func (f *TagFilter) Filter(tags *element.Tags) {
+	if f.loadAll || tags == nil {
+		return
+	}
+	for k := range *tags {
+		if f.exclude[k] {
+			delete(*tags, k)
+		}
+	}
+}
+
+// NodeTagFilter, WayTagFilter and RelationTagFilter all apply the same
+// tags.exclude list from the mapping YAML; they're kept as separate
+// constructors so a future mapping format revision can filter each
+// element type differently without changing every call site.
+func (m *Mapping) NodeTagFilter() *TagFilter     { return m.tagFilter() }
+func (m *Mapping) WayTagFilter() *TagFilter      { return m.tagFilter() }
+func (m *Mapping) RelationTagFilter() *TagFilter { return m.tagFilter() }
+
+func (m *Mapping) tagFilter() *TagFilter {
+	exclude := make(map[string]bool, len(m.Tags.Exclude))
+	for _, k := range m.Tags.Exclude {
+		exclude[k] = true
+	}
+	return &TagFilter{loadAll: m.Tags.LoadAll, exclude: exclude}
+}
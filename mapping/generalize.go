@@ -0,0 +1,28 @@
+package mapping
+
+// GeneralizedTable is a table derived from a base (matched) table by
+// simplifying its geometry to a fixed tolerance, e.g. "roads_gen0" at
+// 50m derived from "roads". It is declared in the mapping YAML under
+// generalized_tables and shares every non-geometry column with its
+// SourceTable.
+type GeneralizedTable struct {
+	Name        string  `yaml:"name"`
+	SourceTable string  `yaml:"source_table"`
+	Tolerance   float64 `yaml:"tolerance"`
+	// SimplifyPreserveTopology selects GEOS SimplifyPreserveTopology over
+	// the (faster but occasionally self-intersecting) plain Simplify.
+	SimplifyPreserveTopology bool `yaml:"simplify_preserve_topology"`
+}
+
+// GeneralizedTablesFor returns the generalized tables derived from
+// table, in the order they appear in the mapping YAML (coarser
+// tolerances are conventionally listed last).
+func (m *Mapping) GeneralizedTablesFor(table string) []*GeneralizedTable {
+	var tables []*GeneralizedTable
+	for _, gt := range m.GeneralizedTables {
+		if gt.SourceTable == table {
+			tables = append(tables, gt)
+		}
+	}
+	return tables
+}
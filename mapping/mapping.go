@@ -0,0 +1,57 @@
+// Package mapping parses the tag-mapping YAML file that drives which OSM
+// elements end up in which output table, and with which columns.
+package mapping
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Column is a single non-geometry output column, populated from an OSM
+// tag by Name (or, if Key is set, from that tag key instead).
+type Column struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+	Type string `yaml:"type"`
+}
+
+// Table is one mapped output table: every element whose tags satisfy
+// Mapping matches it, producing a row with Columns plus a trailing
+// geometry column of GeometryType.
+type Table struct {
+	Name         string              `yaml:"name"`
+	GeometryType string              `yaml:"type"`
+	Mapping      map[string][]string `yaml:"mapping"`
+	Columns      []*Column           `yaml:"columns"`
+}
+
+// Mapping is the parsed tag-mapping YAML: the set of output tables (and
+// the tables generalized from them) a full import or update run matches
+// elements against.
+type Mapping struct {
+	Tags struct {
+		LoadAll bool     `yaml:"load_all"`
+		Exclude []string `yaml:"exclude"`
+	} `yaml:"tags"`
+	Tables            []*Table            `yaml:"tables"`
+	GeneralizedTables []*GeneralizedTable `yaml:"generalized_tables"`
+}
+
+// NewMapping reads and parses filename, a tag-mapping YAML document, into
+// a Mapping. It's a thin wrapper around yaml.Unmarshal; validation of
+// cross-references (e.g. a generalized table's source_table) happens
+// where they're used, since that's where the detail needed for a useful
+// error message (available source tables, the backend name) is at hand.
+func NewMapping(filename string) (*Mapping, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("mapping: reading %s: %v", filename, err)
+	}
+	m := &Mapping{}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("mapping: parsing %s: %v", filename, err)
+	}
+	return m, nil
+}
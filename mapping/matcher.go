@@ -0,0 +1,88 @@
+package mapping
+
+import "goposm/element"
+
+// Match is one mapping table an element's tags satisfied. Row builds the
+// output row for elem: a value per Column (looked up by tag key, blank
+// if the element doesn't carry it) followed by elem.Geom, the column
+// order every output backend's Insert/Update expects.
+type Match struct {
+	Table string
+	table *Table
+}
+
+func (ma Match) Row(elem *element.OSMElem) []interface{} {
+	row := make([]interface{}, 0, len(ma.table.Columns)+1)
+	for _, col := range ma.table.Columns {
+		key := col.Key
+		if key == "" {
+			key = col.Name
+		}
+		row = append(row, elem.Tags[key])
+	}
+	return append(row, elem.Geom)
+}
+
+// NodeMatcher and WayMatcher report which tables, if any, an element's
+// tags map it to. They're the same underlying tableMatcher, split into
+// two names because a node and a way are never matched against the same
+// mapping.Table (GeometryType ties a table to point, linestring or
+// polygon output).
+type NodeMatcher interface {
+	Match(elem *element.OSMElem) []Match
+}
+
+type WayMatcher interface {
+	Match(elem *element.OSMElem) []Match
+}
+
+type tableMatcher struct {
+	tables []*Table
+}
+
+func (tm *tableMatcher) Match(elem *element.OSMElem) []Match {
+	var matches []Match
+	for _, t := range tm.tables {
+		if tableMatches(t, elem) {
+			matches = append(matches, Match{Table: t.Name, table: t})
+		}
+	}
+	return matches
+}
+
+// tableMatches reports whether elem carries a tag key/value pair the
+// table's mapping declares, an empty value list meaning "any value for
+// this key matches".
+func tableMatches(t *Table, elem *element.OSMElem) bool {
+	for key, values := range t.Mapping {
+		v, ok := elem.Tags[key]
+		if !ok {
+			continue
+		}
+		if len(values) == 0 {
+			return true
+		}
+		for _, want := range values {
+			if want == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PointMatcher, LineStringMatcher and PolygonMatcher return a matcher
+// over the subset of Tables declared with the matching GeometryType.
+func (m *Mapping) PointMatcher() NodeMatcher     { return m.matcherFor("point") }
+func (m *Mapping) LineStringMatcher() WayMatcher { return m.matcherFor("linestring") }
+func (m *Mapping) PolygonMatcher() WayMatcher    { return m.matcherFor("polygon") }
+
+func (m *Mapping) matcherFor(geometryType string) *tableMatcher {
+	var tables []*Table
+	for _, t := range m.Tables {
+		if t.GeometryType == geometryType {
+			tables = append(tables, t)
+		}
+	}
+	return &tableMatcher{tables: tables}
+}
@@ -0,0 +1,165 @@
+package parser
+
+import (
+	"encoding/xml"
+	"goposm/element"
+	"io"
+	"os"
+
+	"compress/gzip"
+)
+
+// ChangeAction identifies what an OsmChange entry does to an element.
+type ChangeAction string
+
+const (
+	ChangeCreate ChangeAction = "create"
+	ChangeModify ChangeAction = "modify"
+	ChangeDelete ChangeAction = "delete"
+)
+
+// Change is a single create/modify/delete operation taken from an
+// OsmChange (.osc) document, carrying at most one of Node/Way/Relation.
+type Change struct {
+	Action   ChangeAction
+	Node     *element.Node
+	Way      *element.Way
+	Relation *element.Relation
+}
+
+// osmChangeDoc mirrors the OsmChange XML structure:
+// <osmChange><create>...</create><modify>...</modify><delete>...</delete></osmChange>
+type osmChangeDoc struct {
+	XMLName xml.Name      `xml:"osmChange"`
+	Create  osmChangeElem `xml:"create"`
+	Modify  osmChangeElem `xml:"modify"`
+	Delete  osmChangeElem `xml:"delete"`
+}
+
+type osmChangeElem struct {
+	Nodes     []xmlNode     `xml:"node"`
+	Ways      []xmlWay      `xml:"way"`
+	Relations []xmlRelation `xml:"relation"`
+}
+
+type xmlTag struct {
+	Key   string `xml:"k,attr"`
+	Value string `xml:"v,attr"`
+}
+
+type xmlNode struct {
+	Id  int64    `xml:"id,attr"`
+	Lat float64  `xml:"lat,attr"`
+	Lon float64  `xml:"lon,attr"`
+	Tag []xmlTag `xml:"tag"`
+}
+
+type xmlWay struct {
+	Id  int64    `xml:"id,attr"`
+	Nd  []xmlRef `xml:"nd"`
+	Tag []xmlTag `xml:"tag"`
+}
+
+type xmlRef struct {
+	Ref int64 `xml:"ref,attr"`
+}
+
+type xmlMember struct {
+	Type string `xml:"type,attr"`
+	Ref  int64  `xml:"ref,attr"`
+	Role string `xml:"role,attr"`
+}
+
+type xmlRelation struct {
+	Id     int64       `xml:"id,attr"`
+	Member []xmlMember `xml:"member"`
+	Tag    []xmlTag    `xml:"tag"`
+}
+
+func tagsFromXML(tags []xmlTag) element.Tags {
+	result := make(element.Tags, len(tags))
+	for _, t := range tags {
+		result[t.Key] = t.Value
+	}
+	return result
+}
+
+// ParseChange reads an OsmChange document (optionally gzip compressed, as
+// is conventional for the .osc.gz files produced by osmosis/replication
+// services) and returns the contained operations grouped by action: all
+// creates, then all modifies, then all deletes, each group listing nodes
+// before ways before relations. The interleaving of separate
+// create/modify/delete blocks in the document is not kept.
+func ParseChange(filename string) ([]Change, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if isGzip(filename) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	doc := osmChangeDoc{}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	changes = append(changes, changesFromElem(ChangeCreate, doc.Create)...)
+	changes = append(changes, changesFromElem(ChangeModify, doc.Modify)...)
+	changes = append(changes, changesFromElem(ChangeDelete, doc.Delete)...)
+	return changes, nil
+}
+
+func changesFromElem(action ChangeAction, elem osmChangeElem) []Change {
+	changes := make([]Change, 0, len(elem.Nodes)+len(elem.Ways)+len(elem.Relations))
+	for _, n := range elem.Nodes {
+		changes = append(changes, Change{
+			Action: action,
+			Node: &element.Node{
+				OSMElem: element.OSMElem{Id: n.Id, Tags: tagsFromXML(n.Tag)},
+				Long:    n.Lon,
+				Lat:     n.Lat,
+			},
+		})
+	}
+	for _, w := range elem.Ways {
+		refs := make([]int64, len(w.Nd))
+		for i, nd := range w.Nd {
+			refs[i] = nd.Ref
+		}
+		changes = append(changes, Change{
+			Action: action,
+			Way: &element.Way{
+				OSMElem: element.OSMElem{Id: w.Id, Tags: tagsFromXML(w.Tag)},
+				Refs:    refs,
+			},
+		})
+	}
+	for _, rel := range elem.Relations {
+		members := make([]element.Member, len(rel.Member))
+		for i, m := range rel.Member {
+			members[i] = element.Member{Id: m.Ref, Type: m.Type, Role: m.Role}
+		}
+		changes = append(changes, Change{
+			Action: action,
+			Relation: &element.Relation{
+				OSMElem: element.OSMElem{Id: rel.Id, Tags: tagsFromXML(rel.Tag)},
+				Members: members,
+			},
+		})
+	}
+	return changes
+}
+
+func isGzip(filename string) bool {
+	return len(filename) > 3 && filename[len(filename)-3:] == ".gz"
+}
@@ -0,0 +1,18 @@
+package proj
+
+import "math"
+
+const mercRadius = 6378137.0
+
+// mercTransformer implements Transformer for EPSG:3857 with the standard
+// spherical Web Mercator formula, avoiding a libproj round trip for the
+// overwhelmingly common default projection.
+type mercTransformer struct{}
+
+func (mercTransformer) Srid() int { return 3857 }
+
+func (mercTransformer) Transform(long, lat float64) (x, y float64) {
+	x = long * math.Pi / 180 * mercRadius
+	y = math.Log(math.Tan(math.Pi/4+lat*math.Pi/360)) * mercRadius
+	return x, y
+}
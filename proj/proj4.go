@@ -0,0 +1,55 @@
+package proj
+
+/*
+#cgo LDFLAGS: -lproj
+#include <proj_api.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// proj4Transformer transforms coordinates via libproj for any EPSG code
+// other than 3857. It holds the two projPJ handles (source WGS84 and the
+// destination projection) for the lifetime of an import.
+type proj4Transformer struct {
+	srid int
+	src  C.projPJ
+	dst  C.projPJ
+}
+
+func newProj4Transformer(srid int) (Transformer, error) {
+	srcDef := C.CString("+proj=longlat +datum=WGS84 +no_defs")
+	defer C.free(unsafe.Pointer(srcDef))
+	src := C.pj_init_plus(srcDef)
+	if src == nil {
+		return nil, fmt.Errorf("proj: initializing WGS84 source projection")
+	}
+
+	dstDef := C.CString(fmt.Sprintf("+init=epsg:%d", srid))
+	defer C.free(unsafe.Pointer(dstDef))
+	dst := C.pj_init_plus(dstDef)
+	if dst == nil {
+		C.pj_free(src)
+		return nil, unsupportedSridError{srid}
+	}
+
+	return &proj4Transformer{srid: srid, src: src, dst: dst}, nil
+}
+
+func (t *proj4Transformer) Srid() int { return t.srid }
+
+func (t *proj4Transformer) Transform(long, lat float64) (x, y float64) {
+	cx, cy := C.double(long*degToRad), C.double(lat*degToRad)
+	C.pj_transform(t.src, t.dst, 1, 1, &cx, &cy, nil)
+	if C.pj_is_latlong(t.dst) != 0 {
+		// geographic targets come back in radians
+		return float64(cx) / degToRad, float64(cy) / degToRad
+	}
+	return float64(cx), float64(cy)
+}
+
+const degToRad = 0.017453292519943295
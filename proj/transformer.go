@@ -0,0 +1,64 @@
+// Package proj transforms node coordinates from WGS84 (EPSG:4326, the
+// coordinate system nodes are stored in) into the projection a table is
+// written in. Web Mercator (EPSG:3857) is handled with a closed-form
+// projection; every other EPSG code goes through a cgo-backed
+// Transformer that calls into libproj.
+package proj
+
+import (
+	"fmt"
+	"goposm/element"
+)
+
+// Transformer converts a single WGS84 longitude/latitude pair into the
+// target projection's x/y. Implementations must be safe for concurrent
+// use, since the write phase in main calls into a shared Transformer
+// from multiple worker goroutines.
+type Transformer interface {
+	Transform(long, lat float64) (x, y float64)
+	Srid() int
+}
+
+// NewTransformer returns the Transformer for srid, reusing the
+// closed-form Web Mercator implementation for EPSG:3857 and otherwise
+// opening a proj4 transformation pipeline from EPSG:4326 to srid.
+func NewTransformer(srid int) (Transformer, error) {
+	if srid == 3857 {
+		return mercTransformer{}, nil
+	}
+	return newProj4Transformer(srid)
+}
+
+// TransformNodes projects every node in place using t, mirroring the
+// signature of the old package-level NodesToMerc helper.
+func TransformNodes(t Transformer, nodes []element.Node) {
+	for i := range nodes {
+		TransformNode(t, &nodes[i])
+	}
+}
+
+// TransformNode projects a single node in place.
+func TransformNode(t Transformer, node *element.Node) {
+	node.Long, node.Lat = t.Transform(node.Long, node.Lat)
+}
+
+// NodesToMerc projects nodes into Web Mercator. It is kept for callers
+// that only ever need 3857 and don't want to thread a Transformer
+// through; -srid imports should use NewTransformer/TransformNodes
+// instead so the configured projection is honored.
+func NodesToMerc(nodes []element.Node) {
+	TransformNodes(mercTransformer{}, nodes)
+}
+
+// NodeToMerc projects a single node into Web Mercator. See NodesToMerc.
+func NodeToMerc(node *element.Node) {
+	TransformNode(mercTransformer{}, node)
+}
+
+type unsupportedSridError struct {
+	srid int
+}
+
+func (e unsupportedSridError) Error() string {
+	return fmt.Sprintf("proj: unsupported or unknown EPSG:%d", e.srid)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"goposm/cache"
+	"goposm/element"
+	"goposm/geom"
+	"goposm/geom/geos"
+	"goposm/mapping"
+	"goposm/parser"
+	"goposm/proj"
+	"goposm/writer"
+	"log"
+)
+
+// applyChange parses an OsmChange (.osc.gz) file and replays its
+// create/modify/delete operations against osmCache and diffCache, running
+// the same tag matchers and geometry builders used during a full import
+// and issuing the resulting rows through dbWriter. This keeps a database
+// populated by an earlier -read/-write import in sync without requiring a
+// full re-import. Only the matched base tables are written: generalized
+// tables are not updated, and no -limitto clipping is applied.
+func applyChange(filename string, osmCache *cache.OSMCache, diffCache *cache.DiffCache, tagmapping *mapping.Mapping, transformer proj.Transformer, dbWriter *writer.DbWriter) error {
+	changes, err := parser.ParseChange(filename)
+	if err != nil {
+		return err
+	}
+
+	points := tagmapping.PointMatcher()
+	lineStrings := tagmapping.LineStringMatcher()
+	polygons := tagmapping.PolygonMatcher()
+
+	g := geos.NewGEOS()
+	defer g.Finish()
+
+	for _, ch := range changes {
+		switch {
+		case ch.Node != nil:
+			if err := applyNodeChange(ch, osmCache, diffCache, points, transformer, g, dbWriter); err != nil {
+				log.Println(err)
+			}
+		case ch.Way != nil:
+			if err := applyWayChange(ch, osmCache, diffCache, lineStrings, polygons, transformer, g, dbWriter); err != nil {
+				log.Println(err)
+			}
+		case ch.Relation != nil:
+			if err := applyRelationChange(ch, osmCache, polygons, transformer, dbWriter); err != nil {
+				log.Println(err)
+			}
+		}
+
+		// a changed node may also move ways/relations that reference it
+		// even though the way/relation itself was not part of this
+		// changeset (e.g. a node moved, geometry of the containing way
+		// changes too). Only the dependent ways are rebuilt here;
+		// relations containing those ways are not.
+		if ch.Node != nil {
+			if err := applyDependentWays(ch.Node.Id, osmCache, diffCache, lineStrings, polygons, transformer, g, dbWriter); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func applyNodeChange(ch parser.Change, osmCache *cache.OSMCache, diffCache *cache.DiffCache, points mapping.NodeMatcher, transformer proj.Transformer, g *geos.Geos, dbWriter *writer.DbWriter) error {
+	n := ch.Node
+
+	if ch.Action == parser.ChangeDelete {
+		// .osc delete entries typically carry only an id/version, not
+		// the tags the node was last written with, so match against
+		// the cached element (if we have one) before dropping it.
+		elem := &n.OSMElem
+		if cached, err := osmCache.Nodes.GetNode(n.Id); err == nil {
+			elem = &cached.OSMElem
+		}
+		osmCache.Nodes.DeleteNode(n.Id)
+		return deleteFromMatches(points.Match(elem), n.Id, dbWriter)
+	}
+
+	var oldMatches []mapping.Match
+	if cached, err := osmCache.Nodes.GetNode(n.Id); err == nil {
+		oldMatches = points.Match(&cached.OSMElem)
+	}
+
+	osmCache.Nodes.PutNodes([]element.Node{*n})
+	osmCache.Coords.PutCoords([]element.Node{*n})
+
+	newMatches := points.Match(&n.OSMElem)
+	if len(oldMatches) == 0 && len(newMatches) == 0 {
+		return nil
+	}
+	proj.TransformNode(transformer, n)
+	geomWKB, err := geom.PointWKB(g, *n)
+	if err != nil {
+		return err
+	}
+	n.Geom = geomWKB
+	return writeReconciled(oldMatches, newMatches, &n.OSMElem, n.Id, dbWriter)
+}
+
+func applyWayChange(ch parser.Change, osmCache *cache.OSMCache, diffCache *cache.DiffCache, lineStrings, polygons mapping.WayMatcher, transformer proj.Transformer, g *geos.Geos, dbWriter *writer.DbWriter) error {
+	w := ch.Way
+
+	if ch.Action == parser.ChangeDelete {
+		elem := &w.OSMElem
+		if cached, err := osmCache.Ways.GetWay(w.Id); err == nil {
+			elem = &cached.OSMElem
+		}
+		osmCache.Ways.DeleteWay(w.Id)
+		matches := append(lineStrings.Match(elem), polygons.Match(elem)...)
+		return deleteFromMatches(matches, w.Id, dbWriter)
+	}
+
+	var oldLineStringMatches, oldPolygonMatches []mapping.Match
+	if cached, err := osmCache.Ways.GetWay(w.Id); err == nil {
+		oldLineStringMatches = lineStrings.Match(&cached.OSMElem)
+		if cached.IsClosed() {
+			oldPolygonMatches = polygons.Match(&cached.OSMElem)
+		}
+	}
+
+	osmCache.Ways.PutWays([]element.Way{*w})
+	if err := osmCache.Coords.FillWay(w); err != nil {
+		return err
+	}
+	diffCache.Coords.AddFromWay(w)
+	proj.TransformNodes(transformer, w.Nodes)
+
+	newLineStringMatches := lineStrings.Match(&w.OSMElem)
+	if len(oldLineStringMatches) > 0 || len(newLineStringMatches) > 0 {
+		wkb, err := geom.LineStringWKB(g, w.Nodes)
+		if err != nil {
+			return err
+		}
+		w.Geom = wkb
+		if err := writeReconciled(oldLineStringMatches, newLineStringMatches, &w.OSMElem, w.Id, dbWriter); err != nil {
+			return err
+		}
+	}
+
+	var newPolygonMatches []mapping.Match
+	if w.IsClosed() {
+		newPolygonMatches = polygons.Match(&w.OSMElem)
+	}
+	if len(newPolygonMatches) > 0 {
+		// only a closed way can produce a polygon geometry; a way that
+		// stopped matching (or was never closed) is handled below by
+		// deleting any stale row from oldPolygonMatches, with no need
+		// to build a polygon out of it.
+		wkb, err := geom.PolygonWKB(g, w.Nodes)
+		if err != nil {
+			return err
+		}
+		w.Geom = wkb
+	}
+	if len(oldPolygonMatches) > 0 || len(newPolygonMatches) > 0 {
+		if err := writeReconciled(oldPolygonMatches, newPolygonMatches, &w.OSMElem, w.Id, dbWriter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyRelationChange(ch parser.Change, osmCache *cache.OSMCache, polygons mapping.WayMatcher, transformer proj.Transformer, dbWriter *writer.DbWriter) error {
+	r := ch.Relation
+
+	if ch.Action == parser.ChangeDelete {
+		elem := &r.OSMElem
+		if cached, err := osmCache.Relations.GetRelation(r.Id); err == nil {
+			elem = &cached.OSMElem
+		}
+		osmCache.Relations.DeleteRelation(r.Id)
+		return deleteFromMatches(polygons.Match(elem), r.Id, dbWriter)
+	}
+
+	var oldMatches []mapping.Match
+	if cached, err := osmCache.Relations.GetRelation(r.Id); err == nil {
+		oldMatches = polygons.Match(&cached.OSMElem)
+	}
+
+	osmCache.Relations.PutRelations([]element.Relation{*r})
+	if err := osmCache.Ways.FillMembers(r.Members); err != nil && err != cache.NotFound {
+		return err
+	}
+	for _, m := range r.Members {
+		if m.Way == nil {
+			continue
+		}
+		if err := osmCache.Coords.FillWay(m.Way); err != nil && err != cache.NotFound {
+			return err
+		}
+		proj.TransformNodes(transformer, m.Way.Nodes)
+	}
+
+	if err := geom.BuildRelation(r); err != nil {
+		return err
+	}
+	newMatches := polygons.Match(&r.OSMElem)
+	return writeReconciled(oldMatches, newMatches, &r.OSMElem, r.Id, dbWriter)
+}
+
+// applyDependentWays re-inserts ways that reference a changed node,
+// looked up via diffCache, so their geometry reflects the node's new
+// position even though the way itself wasn't present in the changeset.
+func applyDependentWays(nodeId int64, osmCache *cache.OSMCache, diffCache *cache.DiffCache, lineStrings, polygons mapping.WayMatcher, transformer proj.Transformer, g *geos.Geos, dbWriter *writer.DbWriter) error {
+	wayIds, err := diffCache.Coords.Ways(nodeId)
+	if err == cache.NotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, wayId := range wayIds {
+		w, err := osmCache.Ways.GetWay(wayId)
+		if err != nil {
+			continue
+		}
+		if err := applyWayChange(parser.Change{Action: parser.ChangeModify, Way: w}, osmCache, diffCache, lineStrings, polygons, transformer, g, dbWriter); err != nil {
+			log.Println(err)
+		}
+	}
+	return nil
+}
+
+// reconcileMatches compares the set of tables elem matched before an edit
+// against the set it matches after, keyed by Match.Table: a table only in
+// newMatches means elem just started matching it (insert), a table in
+// both means it already has a row to update, and a table only in
+// oldMatches means elem stopped matching it and its row is now stale
+// (delete). A plain ChangeCreate/ChangeDelete degenerates to this with an
+// empty oldMatches or newMatches respectively.
+func reconcileMatches(oldMatches, newMatches []mapping.Match) (toInsert, toUpdate, toDelete []mapping.Match) {
+	oldTables := make(map[string]mapping.Match, len(oldMatches))
+	for _, m := range oldMatches {
+		oldTables[m.Table] = m
+	}
+	newTables := make(map[string]bool, len(newMatches))
+	for _, m := range newMatches {
+		newTables[m.Table] = true
+		if _, ok := oldTables[m.Table]; ok {
+			toUpdate = append(toUpdate, m)
+		} else {
+			toInsert = append(toInsert, m)
+		}
+	}
+	for table, m := range oldTables {
+		if !newTables[table] {
+			toDelete = append(toDelete, m)
+		}
+	}
+	return
+}
+
+// writeReconciled reconciles oldMatches against newMatches (see
+// reconcileMatches) and issues the resulting inserts/updates/deletes, so
+// a modify that makes elem start or stop matching a table keeps that
+// table's rows consistent with a full re-import, rather than keying a
+// single SQL action off the OsmChange action alone.
+func writeReconciled(oldMatches, newMatches []mapping.Match, elem *element.OSMElem, id int64, dbWriter *writer.DbWriter) error {
+	toInsert, toUpdate, toDelete := reconcileMatches(oldMatches, newMatches)
+	if err := writeMatches(toInsert, elem, id, "insert", dbWriter); err != nil {
+		return err
+	}
+	if err := writeMatches(toUpdate, elem, id, "update", dbWriter); err != nil {
+		return err
+	}
+	return deleteFromMatches(toDelete, id, dbWriter)
+}
+
+func writeMatches(matches []mapping.Match, elem *element.OSMElem, id int64, dbAction string, dbWriter *writer.DbWriter) error {
+	for _, match := range matches {
+		row := match.Row(elem)
+		if err := dbWriter.Apply(dbAction, match.Table, id, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteFromMatches(matches []mapping.Match, id int64, dbWriter *writer.DbWriter) error {
+	for _, match := range matches {
+		if err := dbWriter.Apply("delete", match.Table, id, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
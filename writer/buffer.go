@@ -0,0 +1,34 @@
+package writer
+
+// insertJob is one row queued by InsertBuffer for the DbWriter goroutine
+// draining Out to write it via database.DB.Insert.
+type insertJob struct {
+	table string
+	id    int64
+	row   []interface{}
+}
+
+// InsertBuffer decouples the parallel match/geometry-building goroutines
+// a full import runs (see main's *write branch) from the single DbWriter
+// goroutine that actually talks to the database: Insert is safe to call
+// concurrently from any of them, and queues the row on Out rather than
+// writing it directly.
+type InsertBuffer struct {
+	Out chan insertJob
+}
+
+func NewInsertBuffer() *InsertBuffer {
+	return &InsertBuffer{Out: make(chan insertJob, 1024)}
+}
+
+// Insert queues row for table under id, the same (tag-columns, then
+// geometry) row layout mapping.Match.Row produces.
+func (b *InsertBuffer) Insert(table string, id int64, row []interface{}) {
+	b.Out <- insertJob{table: table, id: id, row: row}
+}
+
+// Close signals that no more rows are coming, once every producer
+// goroutine has finished.
+func (b *InsertBuffer) Close() {
+	close(b.Out)
+}
@@ -0,0 +1,43 @@
+package writer
+
+import (
+	"goposm/database"
+	"log"
+)
+
+// DbWriter drains an InsertBuffer's Out channel in its own goroutine,
+// issuing each buffered row as a database.DB.Insert call, so the
+// parallel goroutines that produce rows during a full import never
+// block on the database directly. An Insert error is logged rather than
+// aborting the import. Backends that batch inserts report a bad row when
+// its batch is flushed, and the rest of that batch is rolled back with it;
+// later batches are still written.
+type DbWriter struct {
+	db   database.DB
+	done chan struct{}
+}
+
+func NewDbWriter(db database.DB, in <-chan insertJob) *DbWriter {
+	dw := &DbWriter{db: db, done: make(chan struct{})}
+	go dw.run(in)
+	return dw
+}
+
+func (dw *DbWriter) run(in <-chan insertJob) {
+	for job := range in {
+		if err := dw.db.Insert(job.table, job.id, job.row); err != nil {
+			log.Println(err)
+		}
+	}
+	close(dw.done)
+}
+
+// Close waits for every row queued before the InsertBuffer was closed to
+// be handed to the database, then closes the database, which writes any
+// rows a batching backend still holds.
+func (dw *DbWriter) Close() {
+	<-dw.done
+	if err := dw.db.Close(); err != nil {
+		log.Println(err)
+	}
+}
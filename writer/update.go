@@ -0,0 +1,18 @@
+package writer
+
+// Apply issues a single INSERT, UPDATE or DELETE against the underlying
+// database for one diff-derived row. It is used by the -update code path
+// in main, which re-matches individual elements touched by an OsmChange
+// file instead of running them through the bulk InsertBuffer pipeline
+// used during a full import.
+func (dw *DbWriter) Apply(action string, table string, id int64, row []interface{}) error {
+	switch action {
+	case "insert":
+		return dw.db.Insert(table, id, row)
+	case "update":
+		return dw.db.Update(table, id, row)
+	case "delete":
+		return dw.db.Delete(table, id)
+	}
+	return nil
+}